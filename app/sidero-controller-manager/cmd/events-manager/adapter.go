@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	sink "github.com/siderolabs/siderolink/pkg/events"
+
+	"github.com/siderolabs/sidero/app/sidero-controller-manager/internal/siderolink"
+)
+
+// Event is the normalized payload handed to each EventHandler, combining the
+// decoded proto event with the node annotation metadata siderolink.Annotator
+// produced for it.
+type Event struct {
+	Node     string
+	TypeURL  string
+	Payload  proto.Message
+	Metadata map[string]string
+}
+
+// EventHandler is implemented by a pluggable sink backend.
+type EventHandler interface {
+	// Name identifies the backend for logging.
+	Name() string
+	// HandleEvent forwards a single normalized event to the backend.
+	HandleEvent(ctx context.Context, event Event) error
+}
+
+// Adapter decodes incoming Talos events, annotates ServerBindings, and fans
+// the normalized event out to every configured EventHandler.
+type Adapter struct {
+	kubeClient runtimeclient.Reader
+	annotator  *siderolink.Annotator
+	logger     *zap.Logger
+	filter     *Filter
+	handlers   []EventHandler
+}
+
+// NewAdapter creates new Adapter.
+func NewAdapter(kubeClient runtimeclient.Reader, annotator *siderolink.Annotator, logger *zap.Logger, filter *Filter, handlers ...EventHandler) *Adapter {
+	return &Adapter{
+		kubeClient: kubeClient,
+		annotator:  annotator,
+		logger:     logger,
+		filter:     filter,
+		handlers:   handlers,
+	}
+}
+
+// HandleEvent implements sink.Adapter.
+func (a *Adapter) HandleEvent(ctx context.Context, event sink.Event) error {
+	node, err := nodeFromEvent(event)
+	if err != nil {
+		a.logger.Warn("failed to resolve node for event", zap.Error(err))
+
+		return nil
+	}
+
+	if !a.filter.AllowEvent(event.TypeURL, event.Payload) {
+		return nil
+	}
+
+	if addressEvent, ok := event.Payload.(*machine.AddressEvent); ok {
+		addresses := make([]string, 0, len(addressEvent.GetAddresses()))
+
+		for _, address := range addressEvent.GetAddresses() {
+			if a.filter.AllowAddress(address) {
+				addresses = append(addresses, address)
+			}
+		}
+
+		event.Payload = &machine.AddressEvent{Hostname: addressEvent.GetHostname(), Addresses: addresses}
+	}
+
+	if err := a.annotator.HandleEvent(ctx, node, event.Payload); err != nil {
+		a.logger.Error("failed to annotate ServerBinding", zap.String("node", node), zap.Error(err))
+	}
+
+	normalized := Event{
+		Node:     node,
+		TypeURL:  event.TypeURL,
+		Payload:  event.Payload,
+		Metadata: a.annotator.Metadata(node),
+	}
+
+	for _, handler := range a.handlers {
+		if err := handler.HandleEvent(ctx, normalized); err != nil {
+			a.logger.Error("sink handler failed", zap.String("sink", handler.Name()), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func nodeFromEvent(event sink.Event) (string, error) {
+	host, _, err := net.SplitHostPort(event.Node)
+	if err != nil {
+		return event.Node, nil
+	}
+
+	return host, nil
+}
@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+// EventTypeRule expresses per-event-type filtering rules for a single event
+// type, e.g. "only PhaseEvent for {boot, install, upgrade}" or "drop
+// ServiceStateEvent for kubelet healthy transitions".
+//
+// Type is matched against the short message name (e.g. "PhaseEvent"), not
+// the full sink.Event.TypeURL (e.g. "type.googleapis.com/talos.machine.
+// PhaseEvent") — see eventTypeKey.
+type EventTypeRule struct {
+	Type         string   `yaml:"type"`
+	AllowPhases  []string `yaml:"allowPhases,omitempty"`
+	DropServices []string `yaml:"dropServices,omitempty"`
+	DropHealthy  bool     `yaml:"dropHealthy,omitempty"`
+}
+
+// eventTypeKey normalizes a proto type URL or fully-qualified type name down
+// to its short message name (e.g. "type.googleapis.com/talos.machine.
+// PhaseEvent" and "talos.machine.PhaseEvent" both become "PhaseEvent") so
+// --filter-config rules can be written using the short names operators
+// actually recognize, regardless of the TypeURL scheme sink.Event uses.
+func eventTypeKey(typeURL string) string {
+	if idx := strings.LastIndexAny(typeURL, "/."); idx >= 0 {
+		return typeURL[idx+1:]
+	}
+
+	return typeURL
+}
+
+// FilterConfig is the structure loaded from the file passed via
+// --filter-config. Flag-provided address filters are merged with whatever is
+// declared here.
+type FilterConfig struct {
+	PositiveAddressFilter []string        `yaml:"positiveAddressFilter,omitempty"`
+	NegativeAddressFilter []string        `yaml:"negativeAddressFilter,omitempty"`
+	EventFilters          []EventTypeRule `yaml:"eventFilters,omitempty"`
+}
+
+func loadFilterConfig(path string) (FilterConfig, error) {
+	if path == "" {
+		return FilterConfig{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FilterConfig{}, fmt.Errorf("failed to read filter config %q: %w", path, err)
+	}
+
+	var cfg FilterConfig
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return FilterConfig{}, fmt.Errorf("failed to parse filter config %q: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Filter evaluates whether an incoming event should be kept, combining
+// address-based and per-event-type rules.
+type Filter struct {
+	positive []netip.Prefix
+	negative []netip.Prefix
+	rules    map[string]EventTypeRule
+}
+
+// NewFilter builds a Filter from parsed prefixes and per-type rules.
+func NewFilter(positive, negative []netip.Prefix, rules []EventTypeRule) *Filter {
+	byType := make(map[string]EventTypeRule, len(rules))
+
+	for _, rule := range rules {
+		byType[eventTypeKey(rule.Type)] = rule
+	}
+
+	return &Filter{
+		positive: positive,
+		negative: negative,
+		rules:    byType,
+	}
+}
+
+// AllowAddress reports whether address should be kept in an AddressEvent.
+// An address matched by the negative filter is always dropped; when a
+// positive filter is configured, only matching addresses are kept.
+func (f *Filter) AllowAddress(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return true
+	}
+
+	for _, prefix := range f.negative {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if len(f.positive) == 0 {
+		return true
+	}
+
+	for _, prefix := range f.positive {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowEvent reports whether the decoded event payload should be kept,
+// applying the per-event-type rule configured for typeURL, if any.
+func (f *Filter) AllowEvent(typeURL string, payload proto.Message) bool {
+	rule, ok := f.rules[eventTypeKey(typeURL)]
+	if !ok {
+		return true
+	}
+
+	switch msg := payload.(type) {
+	case *machine.PhaseEvent:
+		if len(rule.AllowPhases) == 0 {
+			return true
+		}
+
+		for _, phase := range rule.AllowPhases {
+			if msg.GetPhase() == phase {
+				return true
+			}
+		}
+
+		return false
+	case *machine.ServiceStateEvent:
+		if !rule.DropHealthy || !msg.GetHealth().GetHealthy() {
+			return true
+		}
+
+		for _, service := range rule.DropServices {
+			if msg.GetService() == service {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return true
+	}
+}
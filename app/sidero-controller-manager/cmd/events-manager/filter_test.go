@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+)
+
+func mustPrefixes(t *testing.T, cidrs ...string) []netip.Prefix {
+	t.Helper()
+
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			t.Fatalf("failed to parse prefix %q: %s", cidr, err)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+func TestEventTypeKey(t *testing.T) {
+	for _, tt := range []struct {
+		typeURL string
+		want    string
+	}{
+		{typeURL: "type.googleapis.com/talos.machine.PhaseEvent", want: "PhaseEvent"},
+		{typeURL: "talos.machine.PhaseEvent", want: "PhaseEvent"},
+		{typeURL: "PhaseEvent", want: "PhaseEvent"},
+	} {
+		if got := eventTypeKey(tt.typeURL); got != tt.want {
+			t.Errorf("eventTypeKey(%q) = %q, want %q", tt.typeURL, got, tt.want)
+		}
+	}
+}
+
+func TestFilterAllowAddress(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		positive []string
+		negative []string
+		node     string
+		want     bool
+	}{
+		{name: "no filters allows everything", node: "10.0.0.1", want: true},
+		{name: "not an IP is always allowed", node: "not-an-ip", want: true},
+		{name: "negative filter blocks a match", negative: []string{"10.0.0.0/24"}, node: "10.0.0.1", want: false},
+		{name: "negative filter allows a miss", negative: []string{"10.0.0.0/24"}, node: "10.0.1.1", want: true},
+		{name: "positive filter allows a match", positive: []string{"10.0.0.0/24"}, node: "10.0.0.1", want: true},
+		{name: "positive filter blocks a miss", positive: []string{"10.0.0.0/24"}, node: "10.0.1.1", want: false},
+		{name: "negative filter wins over positive", positive: []string{"10.0.0.0/16"}, negative: []string{"10.0.0.0/24"}, node: "10.0.0.1", want: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFilter(mustPrefixes(t, tt.positive...), mustPrefixes(t, tt.negative...), nil)
+
+			if got := f.AllowAddress(tt.node); got != tt.want {
+				t.Errorf("AllowAddress(%q) = %v, want %v", tt.node, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterAllowEventPhase(t *testing.T) {
+	rules := []EventTypeRule{
+		{Type: "type.googleapis.com/talos.machine.PhaseEvent", AllowPhases: []string{"boot", "upgrade"}},
+	}
+
+	f := NewFilter(nil, nil, rules)
+
+	for _, tt := range []struct {
+		name  string
+		phase string
+		want  bool
+	}{
+		{name: "allowed phase", phase: "boot", want: true},
+		{name: "another allowed phase", phase: "upgrade", want: true},
+		{name: "disallowed phase", phase: "install", want: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &machine.PhaseEvent{Phase: tt.phase}
+
+			if got := f.AllowEvent("type.googleapis.com/talos.machine.PhaseEvent", event); got != tt.want {
+				t.Errorf("AllowEvent(phase=%q) = %v, want %v", tt.phase, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterAllowEventPhaseNoRuleAllowsEverything(t *testing.T) {
+	f := NewFilter(nil, nil, nil)
+
+	event := &machine.PhaseEvent{Phase: "anything"}
+
+	if !f.AllowEvent("type.googleapis.com/talos.machine.PhaseEvent", event) {
+		t.Error("expected AllowEvent to allow an event with no configured rule")
+	}
+}
+
+func TestFilterAllowEventServiceState(t *testing.T) {
+	rules := []EventTypeRule{
+		{Type: "talos.machine.ServiceStateEvent", DropHealthy: true, DropServices: []string{"kubelet"}},
+	}
+
+	f := NewFilter(nil, nil, rules)
+
+	for _, tt := range []struct {
+		name    string
+		service string
+		healthy bool
+		want    bool
+	}{
+		{name: "unhealthy service is kept", service: "kubelet", healthy: false, want: true},
+		{name: "healthy dropped service is dropped", service: "kubelet", healthy: true, want: false},
+		{name: "healthy other service is kept", service: "etcd", healthy: true, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &machine.ServiceStateEvent{
+				Service: tt.service,
+				Health:  &machine.ServiceHealth{Healthy: tt.healthy},
+			}
+
+			if got := f.AllowEvent("talos.machine.ServiceStateEvent", event); got != tt.want {
+				t.Errorf("AllowEvent(service=%q, healthy=%v) = %v, want %v", tt.service, tt.healthy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterAllowEventTypeWithoutRuleAllowed(t *testing.T) {
+	rules := []EventTypeRule{
+		{Type: "talos.machine.PhaseEvent", AllowPhases: []string{"boot"}},
+	}
+
+	f := NewFilter(nil, nil, rules)
+
+	if !f.AllowEvent("talos.machine.TaskEvent", &machine.TaskEvent{}) {
+		t.Error("expected AllowEvent to allow an event type with no configured rule")
+	}
+}
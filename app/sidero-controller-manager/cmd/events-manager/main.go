@@ -27,10 +27,23 @@ import (
 	"github.com/siderolabs/sidero/app/sidero-controller-manager/internal/siderolink"
 )
 
-var negativeAddressFilter []string
+var (
+	negativeAddressFilter []string
+	positiveAddressFilter []string
+	filterConfigPath      string
+	sinks                 []string
+	cfg                   sinkConfig
+)
 
 func main() {
 	pflag.StringSliceVar(&negativeAddressFilter, "negative-address-filter", nil, "list of CIDR prefixes to filter out from the address events")
+	pflag.StringSliceVar(&positiveAddressFilter, "positive-address-filter", nil, "list of CIDR prefixes to keep from the address events, dropping everything else")
+	pflag.StringVar(&filterConfigPath, "filter-config", "", "path to a YAML file with address and per-event-type filter rules")
+	pflag.StringSliceVar(&sinks, "sink", []string{"k8s"}, "list of event sink backends to enable (k8s, webhook, kafka, nats, stdout-json)")
+	pflag.StringVar(&cfg.webhookURL, "webhook-url", "", "URL the webhook sink POSTs normalized events to")
+	pflag.StringVar(&cfg.webhookSecret, "webhook-hmac-secret", "", "secret used to HMAC-sign webhook sink requests")
+	pflag.StringSliceVar(&cfg.kafkaBrokers, "kafka-brokers", nil, "list of Kafka broker addresses for the kafka sink")
+	pflag.StringVar(&cfg.natsURL, "nats-url", "", "NATS server URL for the nats sink")
 	pflag.Parse()
 
 	if err := run(); err != nil {
@@ -51,21 +64,23 @@ func run() error {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	negativeFilter := make([]netip.Prefix, 0, len(negativeAddressFilter))
-
-	for _, prefixStr := range negativeAddressFilter {
-		if prefixStr == "-" {
-			continue
-		}
+	filterConfig, err := loadFilterConfig(filterConfigPath)
+	if err != nil {
+		return err
+	}
 
-		prefix, err := netip.ParsePrefix(prefixStr)
-		if err != nil {
-			return err
-		}
+	negativePrefixes, err := parsePrefixes(append(negativeAddressFilter, filterConfig.NegativeAddressFilter...))
+	if err != nil {
+		return err
+	}
 
-		negativeFilter = append(negativeFilter, prefix)
+	positivePrefixes, err := parsePrefixes(append(positiveAddressFilter, filterConfig.PositiveAddressFilter...))
+	if err != nil {
+		return err
 	}
 
+	filter := NewFilter(positivePrefixes, negativePrefixes, filterConfig.EventFilters)
+
 	eg, ctx := errgroup.WithContext(ctx)
 
 	address := fmt.Sprintf(":%d", siderolink.EventsSinkPort)
@@ -84,10 +99,16 @@ func run() error {
 
 	annotator := siderolink.NewAnnotator(client, kubeconfig, logger)
 
+	handlers, err := buildHandlers(sinks, cfg, logger)
+	if err != nil {
+		return fmt.Errorf("error configuring event sinks: %w", err)
+	}
+
 	adapter := NewAdapter(client,
 		annotator,
 		logger.With(zap.String("component", "sink")),
-		negativeFilter,
+		filter,
+		handlers...,
 	)
 
 	srv := sink.NewSink(adapter,
@@ -128,3 +149,22 @@ func run() error {
 
 	return nil
 }
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, prefixStr := range values {
+		if prefixStr == "-" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(prefixStr)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,283 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sinkEnvelope is the normalized, transport-agnostic representation of an
+// Event forwarded by the webhook, Kafka, NATS and stdout-json backends.
+type sinkEnvelope struct {
+	Node     string            `json:"node"`
+	Type     string            `json:"type"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Payload  json.RawMessage   `json:"payload"`
+}
+
+// serverUUIDMetadataKey is the event.Metadata key siderolink.Annotator
+// populates with the server's stable UUID.
+const serverUUIDMetadataKey = "uuid"
+
+// serverKey returns the server's stable UUID so per-server partitioning/
+// keying survives node address churn, falling back to the node address if
+// the annotator hasn't resolved a UUID yet (e.g. before the ServerBinding
+// exists).
+func serverKey(event Event) string {
+	if uuid := event.Metadata[serverUUIDMetadataKey]; uuid != "" {
+		return uuid
+	}
+
+	return event.Node
+}
+
+func newSinkEnvelope(event Event) (sinkEnvelope, error) {
+	// event.Payload is a proto.Message: use protojson rather than
+	// encoding/json so oneofs, enums and well-known types (timestamps,
+	// durations) serialize the way observability pipelines expect instead
+	// of as raw proto-generated struct internals.
+	payload, err := protojson.Marshal(event.Payload)
+	if err != nil {
+		return sinkEnvelope{}, fmt.Errorf("failed to marshal event payload: %w", err)
+	}
+
+	return sinkEnvelope{
+		Node:     event.Node,
+		Type:     event.TypeURL,
+		Metadata: event.Metadata,
+		Payload:  payload,
+	}, nil
+}
+
+// k8sHandler is the original behavior: annotation of ServerBinding CRs, which
+// Adapter.HandleEvent already performs before fanning out. It exists as a
+// handler so "--sink=k8s" can be listed explicitly alongside the other
+// backends without special-casing it.
+type k8sHandler struct{}
+
+func newK8sHandler() *k8sHandler { return &k8sHandler{} }
+
+func (h *k8sHandler) Name() string { return "k8s" }
+
+func (h *k8sHandler) HandleEvent(context.Context, Event) error { return nil }
+
+// stdoutJSONHandler writes each event as a single JSON line to stdout, useful
+// for fleet log shippers.
+type stdoutJSONHandler struct{}
+
+func newStdoutJSONHandler() *stdoutJSONHandler { return &stdoutJSONHandler{} }
+
+func (h *stdoutJSONHandler) Name() string { return "stdout-json" }
+
+func (h *stdoutJSONHandler) HandleEvent(_ context.Context, event Event) error {
+	envelope, err := newSinkEnvelope(event)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(encoded))
+
+	return nil
+}
+
+// webhookHandler POSTs a normalized JSON envelope to an HTTP endpoint, signing
+// the body with HMAC-SHA256 when a secret is configured.
+type webhookHandler struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookHandler(url, secret string) *webhookHandler {
+	return &webhookHandler{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *webhookHandler) Name() string { return "webhook" }
+
+func (h *webhookHandler) HandleEvent(ctx context.Context, event Event) error {
+	envelope, err := newSinkEnvelope(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(h.secret) > 0 {
+		mac := hmac.New(sha256.New, h.secret)
+		mac.Write(body)
+		req.Header.Set("X-Sidero-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// kafkaHandler publishes events to per-event-type topics, keyed by the
+// originating server's UUID so partitioning stays stable across node
+// address changes.
+type kafkaHandler struct {
+	writer *kafka.Writer
+}
+
+func newKafkaHandler(brokers []string) *kafkaHandler {
+	return &kafkaHandler{
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Balancer: &kafka.LeastBytes{},
+		},
+	}
+}
+
+func (h *kafkaHandler) Name() string { return "kafka" }
+
+func (h *kafkaHandler) HandleEvent(ctx context.Context, event Event) error {
+	envelope, err := newSinkEnvelope(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	return h.writer.WriteMessages(ctx, kafka.Message{
+		Topic: fmt.Sprintf("sidero.events.%s", event.TypeURL),
+		Key:   []byte(serverKey(event)),
+		Value: body,
+	})
+}
+
+// natsHandler publishes events to per-event-type subjects, keyed by the
+// originating server's UUID via a message header — NATS core pub/sub has no
+// native per-message key like Kafka's, so a header is the closest
+// equivalent for downstream consumers that want to partition or dedupe by
+// server.
+type natsHandler struct {
+	conn *nats.Conn
+}
+
+func newNATSHandler(url string) (*natsHandler, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	return &natsHandler{conn: conn}, nil
+}
+
+func (h *natsHandler) Name() string { return "nats" }
+
+func (h *natsHandler) HandleEvent(_ context.Context, event Event) error {
+	envelope, err := newSinkEnvelope(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	msg := &nats.Msg{
+		Subject: fmt.Sprintf("sidero.events.%s", event.TypeURL),
+		Data:    body,
+		Header:  nats.Header{"Sidero-Server-Uuid": []string{serverKey(event)}},
+	}
+
+	return h.conn.PublishMsg(msg)
+}
+
+// sinkConfig carries the flag values needed to construct the handlers
+// selected by --sink.
+type sinkConfig struct {
+	webhookURL    string
+	webhookSecret string
+	kafkaBrokers  []string
+	natsURL       string
+}
+
+func buildHandlers(sinks []string, cfg sinkConfig, logger *zap.Logger) ([]EventHandler, error) {
+	handlers := make([]EventHandler, 0, len(sinks))
+
+	for _, name := range sinks {
+		switch name {
+		case "k8s":
+			handlers = append(handlers, newK8sHandler())
+		case "stdout-json":
+			handlers = append(handlers, newStdoutJSONHandler())
+		case "webhook":
+			if cfg.webhookURL == "" {
+				return nil, fmt.Errorf("--webhook-url is required for the webhook sink")
+			}
+
+			handlers = append(handlers, newWebhookHandler(cfg.webhookURL, cfg.webhookSecret))
+		case "kafka":
+			if len(cfg.kafkaBrokers) == 0 {
+				return nil, fmt.Errorf("--kafka-brokers is required for the kafka sink")
+			}
+
+			handlers = append(handlers, newKafkaHandler(cfg.kafkaBrokers))
+		case "nats":
+			if cfg.natsURL == "" {
+				return nil, fmt.Errorf("--nats-url is required for the nats sink")
+			}
+
+			handler, err := newNATSHandler(cfg.natsURL)
+			if err != nil {
+				return nil, err
+			}
+
+			handlers = append(handlers, handler)
+		default:
+			return nil, fmt.Errorf("unknown sink backend %q", name)
+		}
+	}
+
+	logger.Info("configured event sinks", zap.Strings("sinks", sinks))
+
+	return handlers, nil
+}
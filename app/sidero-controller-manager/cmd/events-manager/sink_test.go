@@ -0,0 +1,37 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import "testing"
+
+func TestServerKey(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			name:  "uses the annotated server UUID",
+			event: Event{Node: "10.0.0.1", Metadata: map[string]string{"uuid": "0000-uuid"}},
+			want:  "0000-uuid",
+		},
+		{
+			name:  "falls back to the node address when the UUID isn't resolved yet",
+			event: Event{Node: "10.0.0.1", Metadata: map[string]string{}},
+			want:  "10.0.0.1",
+		},
+		{
+			name:  "falls back to the node address when there is no metadata at all",
+			event: Event{Node: "10.0.0.1"},
+			want:  "10.0.0.1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverKey(tt.event); got != tt.want {
+				t.Errorf("serverKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,36 +8,105 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/netip"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	cabpt "github.com/siderolabs/cluster-api-bootstrap-provider-talos/api/v1alpha3"
 	cacpt "github.com/siderolabs/cluster-api-control-plane-provider-talos/api/v1alpha3"
 	"github.com/siderolabs/go-retry/retry"
 	taloscluster "github.com/siderolabs/talos/pkg/cluster"
 	talosclusterapi "github.com/siderolabs/talos/pkg/machinery/api/cluster"
 	talosclient "github.com/siderolabs/talos/pkg/machinery/client"
 	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	capiv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Cluster attaches to the provisioned CAPI cluster and provides talos.Cluster.
 type Cluster struct {
-	name              string
-	controlPlaneNodes []string
-	workerNodes       []string
-	bridgeIP          netip.Addr
-	client            *talosclient.Client
-	k8sProvider       *taloscluster.KubernetesClient
+	name   string
+	nodeMu sync.RWMutex
+	// controlPlaneNodes and workerNodes are guarded by nodeMu: Upgrade's
+	// Recreate strategy re-resolves them mid-upgrade once a deleted
+	// machine's replacement rejoins, while Health/probeNode read them
+	// concurrently from other in-flight node upgrades.
+	controlPlaneNodes    []string
+	workerNodes          []string
+	controlPlaneSelector labels.Selector
+	workerSelector       labels.Selector
+	bridgeIP             netip.Addr
+	client               *talosclient.Client
+	k8sProvider          *taloscluster.KubernetesClient
+	metalClient          runtimeclient.Reader
+	componentsIP         componentsIPCache
+	replacements         replacementTracker
 }
 
+// replacementTracker records which freshly observed CAPI Machine UIDs have
+// already been claimed as some other node's Recreate replacement, so two
+// concurrent Recreate upgrades (UpgradeOptions.MaxUnavailable > 1) can't both
+// match the same newly-provisioned machine as "their" replacement.
+type replacementTracker struct {
+	mu      sync.Mutex
+	claimed map[types.UID]struct{}
+}
+
+// claim reports whether uid was not yet claimed by another waiter, claiming
+// it for the caller if so.
+func (t *replacementTracker) claim(uid types.UID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.claimed == nil {
+		t.claimed = make(map[types.UID]struct{})
+	}
+
+	if _, taken := t.claimed[uid]; taken {
+		return false
+	}
+
+	t.claimed[uid] = struct{}{}
+
+	return true
+}
+
+// componentsIPCache caches the resolved Sidero components IP for a short TTL
+// so health loops and upgrade retries don't hammer the management cluster's
+// API server.
+type componentsIPCache struct {
+	mu        sync.Mutex
+	ip        net.IP
+	expiresAt time.Time
+}
+
+const sideroComponentsIPTTL = 30 * time.Second
+
+// Ports Sidero's own services listen on in the management cluster.
+const (
+	tftpPort       = 69
+	ipxeHTTPPort   = 8081
+	metadataPort   = 8081
+	sideroLinkPort = 51821
+	eventSinkPort  = 8090
+)
+
 // NewCluster fetches cluster info from the CAPI state.
 func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterName string, bridgeIP netip.Addr) (*Cluster, error) {
 	var (
@@ -45,7 +114,6 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 		controlPlane       cacpt.TalosControlPlane
 		machines           capiv1.MachineList
 		machineDeployments capiv1.MachineDeploymentList
-		talosSecret        v1.Secret
 	)
 
 	if err := metalClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: clusterName}, &cluster); err != nil {
@@ -56,12 +124,12 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 		return nil, err
 	}
 
-	labelSelector, err := labels.Parse(controlPlane.Status.Selector)
+	controlPlaneSelector, err := labels.Parse(controlPlane.Status.Selector)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+	if err = metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: controlPlaneSelector}); err != nil {
 		return nil, err
 	}
 
@@ -69,15 +137,11 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 		return nil, fmt.Errorf("not enough machines found")
 	}
 
-	if err = metalClient.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: fmt.Sprintf("%s-talosconfig", cluster.Name)}, &talosSecret); err != nil {
+	config, err := fetchTalosConfig(ctx, metalClient, cluster, machines)
+	if err != nil {
 		return nil, err
 	}
 
-	config, ok := talosSecret.Data["talosconfig"]
-	if !ok {
-		return nil, fmt.Errorf("failed to find talosconfig data in the talosconfig secret")
-	}
-
 	var clientConfig *clientconfig.Config
 
 	clientConfig, err = clientconfig.FromBytes(config)
@@ -85,30 +149,18 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 		return nil, err
 	}
 
-	resolveMachinesToIPs := func(machines capiv1.MachineList) []string {
-		var endpoints []string
-
-		for _, machine := range machines.Items {
-			if !machine.DeletionTimestamp.IsZero() {
-				continue
-			}
-
-			if capiv1.MachinePhase(machine.Status.Phase) != capiv1.MachinePhaseRunning && capiv1.MachinePhase(machine.Status.Phase) != capiv1.MachinePhaseProvisioned {
-				continue
-			}
+	controlPlaneNodes := resolveMachinesToIPs(machines)
 
-			for _, address := range machine.Status.Addresses {
-				if address.Type == capiv1.MachineInternalIP {
-					endpoints = append(endpoints, address.Address)
-				}
-			}
+	if len(controlPlaneNodes) < 1 {
+		// Older TalosControlPlane templates using the "init" node scheme don't
+		// populate machine.Status.Addresses; fall back to the workload
+		// cluster's own Node objects.
+		controlPlaneNodes, err = controlPlaneNodesFromWorkloadCluster(ctx, metalClient, cluster)
+		if err != nil {
+			return nil, err
 		}
-
-		return endpoints
 	}
 
-	controlPlaneNodes := resolveMachinesToIPs(machines)
-
 	if len(controlPlaneNodes) < 1 {
 		return nil, fmt.Errorf("failed to find control plane nodes")
 	}
@@ -121,12 +173,12 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 		return nil, fmt.Errorf("unexpected number of machine deployments: %d", len(machineDeployments.Items))
 	}
 
-	labelSelector, err = labels.Parse(machineDeployments.Items[0].Status.Selector)
+	workerSelector, err := labels.Parse(machineDeployments.Items[0].Status.Selector)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+	if err = metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: workerSelector}); err != nil {
 		return nil, err
 	}
 
@@ -143,31 +195,705 @@ func NewCluster(ctx context.Context, metalClient runtimeclient.Reader, clusterNa
 	}
 
 	return &Cluster{
-		name:              clusterName,
-		controlPlaneNodes: controlPlaneNodes,
-		workerNodes:       workerNodes,
-		bridgeIP:          bridgeIP,
-		client:            talosClient,
+		name:                 clusterName,
+		controlPlaneNodes:    controlPlaneNodes,
+		workerNodes:          workerNodes,
+		controlPlaneSelector: controlPlaneSelector,
+		workerSelector:       workerSelector,
+		bridgeIP:             bridgeIP,
+		client:               talosClient,
 		k8sProvider: &taloscluster.KubernetesClient{
 			ClientProvider: &taloscluster.ConfigClientProvider{
 				DefaultClient: talosClient,
 			},
 		},
+		metalClient: metalClient,
 	}, nil
 }
 
-// Health runs the healthcheck for the cluster.
+// resolveMachinesToIPs returns the internal IP of every machine in machines
+// that is actually up (not being deleted, and Running or Provisioned).
+func resolveMachinesToIPs(machines capiv1.MachineList) []string {
+	var endpoints []string
+
+	for _, machine := range machines.Items {
+		if !machine.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if capiv1.MachinePhase(machine.Status.Phase) != capiv1.MachinePhaseRunning && capiv1.MachinePhase(machine.Status.Phase) != capiv1.MachinePhaseProvisioned {
+			continue
+		}
+
+		for _, address := range machine.Status.Addresses {
+			if address.Type == capiv1.MachineInternalIP {
+				endpoints = append(endpoints, address.Address)
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// firstInternalIP returns the first internal IP address of machine, or "" if
+// it doesn't have one yet.
+func firstInternalIP(machine *capiv1.Machine) string {
+	for _, address := range machine.Status.Addresses {
+		if address.Type == capiv1.MachineInternalIP {
+			return address.Address
+		}
+	}
+
+	return ""
+}
+
+// fetchTalosConfig returns the talosconfig for the cluster, preferring the
+// "<cluster>-talosconfig" secret and falling back to the status of a ready
+// TalosConfig resource owned by one of the control plane machines. Older
+// TalosControlPlane templates never created the secret, so this mirrors the
+// fallback the Talos control-plane-provider itself added for that case.
+func fetchTalosConfig(ctx context.Context, metalClient runtimeclient.Reader, cluster capiv1.Cluster, controlPlaneMachines capiv1.MachineList) ([]byte, error) {
+	var talosSecret v1.Secret
+
+	err := metalClient.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: fmt.Sprintf("%s-talosconfig", cluster.Name)}, &talosSecret)
+
+	switch {
+	case err == nil:
+		config, ok := talosSecret.Data["talosconfig"]
+		if !ok {
+			return nil, fmt.Errorf("failed to find talosconfig data in the talosconfig secret")
+		}
+
+		return config, nil
+	case apierrors.IsNotFound(err):
+		return talosConfigFromMachines(ctx, metalClient, controlPlaneMachines)
+	default:
+		return nil, err
+	}
+}
+
+// talosConfigFromMachines looks up the TalosConfig resource referenced by
+// each control plane machine's bootstrap config and returns the client
+// credentials from the first one that is ready.
+func talosConfigFromMachines(ctx context.Context, metalClient runtimeclient.Reader, machines capiv1.MachineList) ([]byte, error) {
+	for _, machine := range machines.Items {
+		if machine.Spec.Bootstrap.ConfigRef == nil {
+			continue
+		}
+
+		var talosConfig cabpt.TalosConfig
+
+		if err := metalClient.Get(ctx, types.NamespacedName{
+			Namespace: machine.Spec.Bootstrap.ConfigRef.Namespace,
+			Name:      machine.Spec.Bootstrap.ConfigRef.Name,
+		}, &talosConfig); err != nil {
+			continue
+		}
+
+		if !talosConfig.Status.Ready || talosConfig.Status.TalosConfig == "" {
+			continue
+		}
+
+		return []byte(talosConfig.Status.TalosConfig), nil
+	}
+
+	return nil, fmt.Errorf("failed to find a ready TalosConfig owned by the control plane machines")
+}
+
+// controlPlaneNodesFromWorkloadCluster lists the workload cluster's own Node
+// objects (via the "<cluster>-kubeconfig" secret) and returns the internal IP
+// of each control plane node. Used as a fallback when control plane machines
+// don't have their addresses populated, as is the case with the "init" node
+// scheme of older TalosControlPlane templates.
+func controlPlaneNodesFromWorkloadCluster(ctx context.Context, metalClient runtimeclient.Reader, cluster capiv1.Cluster) ([]string, error) {
+	var kubeconfigSecret v1.Secret
+
+	if err := metalClient.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: fmt.Sprintf("%s-kubeconfig", cluster.Name)}, &kubeconfigSecret); err != nil {
+		return nil, err
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigSecret.Data["value"])
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: "node-role.kubernetes.io/control-plane",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []string
+
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				endpoints = append(endpoints, address.Address)
+			}
+		}
+	}
+
+	return endpoints, nil
+}
+
+// UpgradeStrategy selects how Upgrade rolls a new Talos version out to cluster nodes.
+type UpgradeStrategy string
+
+const (
+	// UpgradeStrategyInPlace applies the new Talos image to each node via the Talos upgrade API,
+	// keeping the node's existing ServerBinding.
+	UpgradeStrategyInPlace UpgradeStrategy = "InPlace"
+	// UpgradeStrategyRecreate drains and deallocates each node's Server, then re-provisions it
+	// against the current MachineTemplate.
+	UpgradeStrategyRecreate UpgradeStrategy = "Recreate"
+)
+
+// UpgradeEvent reports progress of a single node during an Upgrade.
+type UpgradeEvent struct {
+	Node  string
+	Stage string
+	Err   error
+}
+
+// UpgradeOptions configures an Upgrade call.
+type UpgradeOptions struct {
+	// MaxUnavailable bounds how many nodes are upgraded concurrently. Defaults to 1 if not positive.
+	MaxUnavailable int
+	// EventSink, if set, receives progress events as the upgrade proceeds.
+	EventSink func(UpgradeEvent)
+}
+
+// UpgradeFlags is the companion flag surface for Upgrade: it lets a CLI
+// entrypoint expose --upgrade-strategy and --upgrade-max-unavailable next to
+// NewCluster/Upgrade, instead of every caller re-implementing the strategy
+// enum's parsing and validation.
+type UpgradeFlags struct {
+	strategy       string
+	maxUnavailable int
+}
+
+// RegisterUpgradeFlags registers the flags backing Strategy/Options on fs and
+// returns the value they'll be parsed into.
+func RegisterUpgradeFlags(fs *pflag.FlagSet) *UpgradeFlags {
+	flags := &UpgradeFlags{}
+
+	fs.StringVar(&flags.strategy, "upgrade-strategy", string(UpgradeStrategyInPlace), "Talos upgrade strategy to use (InPlace, Recreate)")
+	fs.IntVar(&flags.maxUnavailable, "upgrade-max-unavailable", 1, "maximum number of nodes to upgrade concurrently")
+
+	return flags
+}
+
+// Strategy validates and returns the configured UpgradeStrategy.
+func (flags *UpgradeFlags) Strategy() (UpgradeStrategy, error) {
+	switch strategy := UpgradeStrategy(flags.strategy); strategy {
+	case UpgradeStrategyInPlace, UpgradeStrategyRecreate:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown --upgrade-strategy %q", flags.strategy)
+	}
+}
+
+// Options returns the UpgradeOptions described by the flags. The caller is
+// responsible for setting EventSink, which has no flag equivalent.
+func (flags *UpgradeFlags) Options() UpgradeOptions {
+	return UpgradeOptions{MaxUnavailable: flags.maxUnavailable}
+}
+
+func (cluster *Cluster) emitUpgradeEvent(opts UpgradeOptions, node, stage string, err error) {
+	if opts.EventSink == nil {
+		return
+	}
+
+	opts.EventSink(UpgradeEvent{Node: node, Stage: stage, Err: err})
+}
+
+// Upgrade upgrades all control plane and worker nodes using strategy. With
+// UpgradeStrategyInPlace, targetVersion is passed to the Talos upgrade API as
+// the installer image to apply; UpgradeStrategyRecreate does not use
+// targetVersion and re-provisions each node from the current MachineTemplate.
+//
+// RegisterUpgradeFlags provides the flag surface (--upgrade-strategy,
+// --upgrade-max-unavailable) for a caller to build the strategy/opts
+// arguments from; this snapshot doesn't wire that into an actual "sfyra"
+// CLI command, so Upgrade is currently only exercised programmatically, via
+// sfyra's own test suite.
+func (cluster *Cluster) Upgrade(ctx context.Context, strategy UpgradeStrategy, targetVersion string, opts UpgradeOptions) error {
+	controlPlaneNodes, workerNodes := cluster.nodeSnapshot()
+
+	nodes := make([]string, 0, len(controlPlaneNodes)+len(workerNodes))
+	nodes = append(nodes, controlPlaneNodes...)
+	nodes = append(nodes, workerNodes...)
+
+	return runUpgrade(ctx, nodes, opts.MaxUnavailable, func(ctx context.Context, node string) error {
+		switch strategy {
+		case UpgradeStrategyInPlace:
+			return cluster.upgradeInPlace(ctx, node, targetVersion, opts)
+		case UpgradeStrategyRecreate:
+			return cluster.upgradeRecreate(ctx, node, targetVersion, opts)
+		default:
+			return fmt.Errorf("unknown upgrade strategy %q", strategy)
+		}
+	})
+}
+
+// runUpgrade dispatches upgradeFn for every node in nodes, bounding how many
+// run concurrently to maxUnavailable (which defaults to 1 if not positive),
+// and returns the first error encountered. Split out of Upgrade so the
+// concurrency bound and strategy dispatch can be unit-tested without a real
+// Talos/CAPI client.
+func runUpgrade(ctx context.Context, nodes []string, maxUnavailable int, upgradeFn func(ctx context.Context, node string) error) error {
+	if maxUnavailable <= 0 {
+		maxUnavailable = 1
+	}
+
+	sem := make(chan struct{}, maxUnavailable)
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	for _, node := range nodes {
+		node := node
+
+		eg.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-sem }()
+
+			return upgradeFn(ctx, node)
+		})
+	}
+
+	return eg.Wait()
+}
+
+func (cluster *Cluster) upgradeInPlace(ctx context.Context, node, targetVersion string, opts UpgradeOptions) error {
+	cluster.emitUpgradeEvent(opts, node, "upgrading", nil)
+
+	if _, err := cluster.client.Upgrade(talosclient.WithNodes(ctx, node), targetVersion, false, false, false); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "upgrading", err)
+
+		return fmt.Errorf("failed to upgrade node %s: %w", node, err)
+	}
+
+	if err := cluster.Health(ctx); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "health", err)
+
+		return fmt.Errorf("node %s failed health check after upgrade: %w", node, err)
+	}
+
+	cluster.emitUpgradeEvent(opts, node, "ready", nil)
+
+	return nil
+}
+
+func (cluster *Cluster) upgradeRecreate(ctx context.Context, node, targetVersion string, opts UpgradeOptions) error {
+	cluster.emitUpgradeEvent(opts, node, "draining", nil)
+
+	machine, err := cluster.machineForNode(ctx, node)
+	if err != nil {
+		return fmt.Errorf("failed to find machine for node %s: %w", node, err)
+	}
+
+	if err := cluster.drainNode(ctx, node); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "draining", err)
+
+		return fmt.Errorf("failed to drain node %s: %w", node, err)
+	}
+
+	writer, ok := cluster.metalClient.(runtimeclient.Client)
+	if !ok {
+		return fmt.Errorf("recreate strategy requires a writable metal client")
+	}
+
+	controlPlane := cluster.controlPlaneSelector != nil && cluster.controlPlaneSelector.Matches(labels.Set(machine.Labels))
+
+	selector := cluster.workerSelector
+	if controlPlane {
+		selector = cluster.controlPlaneSelector
+	}
+
+	// Snapshotted before Delete so waitForReplacementNode can tell "a machine
+	// that already existed" apart from "the machine CAPI provisioned to
+	// replace this one" by identity, rather than by diffing IP address
+	// lists — which breaks as soon as more than one node is being recreated
+	// at once (UpgradeOptions.MaxUnavailable > 1).
+	beforeUIDs, err := cluster.machineUIDs(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot machines before deallocating node %s: %w", node, err)
+	}
+
+	cluster.emitUpgradeEvent(opts, node, "deallocating", nil)
+
+	if err := writer.Delete(ctx, machine); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "deallocating", err)
+
+		return fmt.Errorf("failed to deallocate machine for node %s: %w", node, err)
+	}
+
+	cluster.emitUpgradeEvent(opts, node, "rejoining", nil)
+
+	// The deleted machine is re-provisioned by the control plane/machine
+	// deployment controllers and very likely comes up on a different
+	// address (bare-metal PXE/DHCP), so cluster.controlPlaneNodes/
+	// workerNodes must be refreshed before Health probes the cluster, or
+	// it just keeps hitting the now-gone old address until it times out.
+	if _, err := cluster.waitForReplacementNode(ctx, node, selector, beforeUIDs); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "rejoining", err)
+
+		return fmt.Errorf("replacement for node %s failed to rejoin: %w", node, err)
+	}
+
+	if err := cluster.Health(ctx); err != nil {
+		cluster.emitUpgradeEvent(opts, node, "rejoining", err)
+
+		return fmt.Errorf("replacement for node %s failed to rejoin: %w", node, err)
+	}
+
+	cluster.emitUpgradeEvent(opts, node, "ready", nil)
+
+	return nil
+}
+
+// waitForReplacementNode polls selector's machines for the one CAPI
+// provisioned to replace staleNode, refreshing cluster.controlPlaneNodes/
+// workerNodes once it appears so the subsequent Health call probes the new
+// address instead of the deleted one.
+//
+// A machine is only accepted as staleNode's replacement if its UID is both
+// absent from beforeUIDs (the selector's machine set snapshotted right
+// before staleNode's machine was deleted) and not already claimed by another
+// concurrent waitForReplacementNode call — plain IP-list diffing can't tell
+// two nodes' simultaneous replacements apart when UpgradeOptions.
+// MaxUnavailable > 1, so identity has to be tracked via Machine UID instead.
+func (cluster *Cluster) waitForReplacementNode(ctx context.Context, staleNode string, selector labels.Selector, beforeUIDs map[types.UID]struct{}) (string, error) {
+	var replacement string
+
+	retryErr := retry.Constant(5*time.Minute, retry.WithUnits(10*time.Second)).Retry(func() error {
+		var machines capiv1.MachineList
+
+		if err := cluster.metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return retry.ExpectedError(err)
+		}
+
+		for i := range machines.Items {
+			m := &machines.Items[i]
+
+			if !m.DeletionTimestamp.IsZero() {
+				continue
+			}
+
+			if _, existed := beforeUIDs[m.UID]; existed {
+				continue
+			}
+
+			if capiv1.MachinePhase(m.Status.Phase) != capiv1.MachinePhaseRunning && capiv1.MachinePhase(m.Status.Phase) != capiv1.MachinePhaseProvisioned {
+				continue
+			}
+
+			addr := firstInternalIP(m)
+			if addr == "" {
+				continue
+			}
+
+			if !cluster.replacements.claim(m.UID) {
+				continue
+			}
+
+			replacement = addr
+
+			break
+		}
+
+		if replacement == "" {
+			return retry.ExpectedError(fmt.Errorf("replacement for node %s has not joined yet", staleNode))
+		}
+
+		return nil
+	})
+	if retryErr != nil {
+		return "", retryErr
+	}
+
+	if err := cluster.refreshNodeAddresses(ctx); err != nil {
+		return "", err
+	}
+
+	return replacement, nil
+}
+
+// machineUIDs lists the UIDs of every non-deleted machine matching selector,
+// for use as a "before" snapshot by waitForReplacementNode.
+func (cluster *Cluster) machineUIDs(ctx context.Context, selector labels.Selector) (map[types.UID]struct{}, error) {
+	var machines capiv1.MachineList
+
+	if err := cluster.metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	uids := make(map[types.UID]struct{}, len(machines.Items))
+
+	for _, m := range machines.Items {
+		if !m.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		uids[m.UID] = struct{}{}
+	}
+
+	return uids, nil
+}
+
+// nodeSnapshot returns a copy of the current control plane and worker node
+// address lists, safe to read concurrently with refreshNodeAddresses.
+func (cluster *Cluster) nodeSnapshot() (controlPlaneNodes, workerNodes []string) {
+	cluster.nodeMu.RLock()
+	defer cluster.nodeMu.RUnlock()
+
+	return append([]string(nil), cluster.controlPlaneNodes...), append([]string(nil), cluster.workerNodes...)
+}
+
+// refreshNodeAddresses re-lists the cluster's control plane and worker
+// machines and replaces cluster.controlPlaneNodes/workerNodes with their
+// current addresses. It only covers the selector-based machine address
+// lookup used by the common path in NewCluster, not the workload-cluster
+// Node fallback for legacy "init"-scheme TalosControlPlane templates.
+func (cluster *Cluster) refreshNodeAddresses(ctx context.Context) error {
+	controlPlaneNodes, err := cluster.resolveNodesBySelector(ctx, cluster.controlPlaneSelector)
+	if err != nil {
+		return err
+	}
+
+	workerNodes, err := cluster.resolveNodesBySelector(ctx, cluster.workerSelector)
+	if err != nil {
+		return err
+	}
+
+	cluster.nodeMu.Lock()
+	defer cluster.nodeMu.Unlock()
+
+	cluster.controlPlaneNodes = controlPlaneNodes
+	cluster.workerNodes = workerNodes
+
+	return nil
+}
+
+// resolveNodesBySelector lists the cluster's machines matching selector and
+// returns their resolved addresses.
+func (cluster *Cluster) resolveNodesBySelector(ctx context.Context, selector labels.Selector) ([]string, error) {
+	var machines capiv1.MachineList
+
+	if err := cluster.metalClient.List(ctx, &machines, runtimeclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	return resolveMachinesToIPs(machines), nil
+}
+
+// machineForNode finds the CAPI Machine backing the given node address.
+func (cluster *Cluster) machineForNode(ctx context.Context, node string) (*capiv1.Machine, error) {
+	var machines capiv1.MachineList
+
+	if err := cluster.metalClient.List(ctx, &machines, runtimeclient.MatchingLabels{"cluster.x-k8s.io/cluster-name": cluster.name}); err != nil {
+		return nil, err
+	}
+
+	for i := range machines.Items {
+		for _, address := range machines.Items[i].Status.Addresses {
+			if address.Type == capiv1.MachineInternalIP && address.Address == node {
+				return &machines.Items[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no machine found for node %s", node)
+}
+
+// drainNode cordons the node and evicts its pods ahead of a Recreate upgrade.
+func (cluster *Cluster) drainNode(ctx context.Context, node string) error {
+	clientset, err := cluster.k8sProvider.K8sClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Kubernetes client: %w", err)
+	}
+
+	nodeObj, err := clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", node, err)
+	}
+
+	if !nodeObj.Spec.Unschedulable {
+		nodeObj.Spec.Unschedulable = true
+
+		if _, err := clientset.CoreV1().Nodes().Update(ctx, nodeObj, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to cordon node %s: %w", node, err)
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", node),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods on node %s: %w", node, err)
+	}
+
+	for _, pod := range pods.Items {
+		if isOwnedByDaemonSet(pod) {
+			continue
+		}
+
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		}
+
+		if err := clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to evict pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func isOwnedByDaemonSet(pod v1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NodeHealthResult is the outcome of a single node's health probe.
+type NodeHealthResult struct {
+	Node     string
+	Stage    string
+	Err      error
+	Duration time.Duration
+}
+
+// ClusterHealthReport aggregates the NodeHealthResult of every probed node.
+type ClusterHealthReport struct {
+	Results []NodeHealthResult
+}
+
+// Err returns the first node failure in the report, if any.
+func (report ClusterHealthReport) Err() error {
+	for _, result := range report.Results {
+		if result.Err != nil {
+			return fmt.Errorf("node %s failed health check: %w", result.Node, result.Err)
+		}
+	}
+
+	return nil
+}
+
+// Healthy reports whether every probed node succeeded.
+func (report ClusterHealthReport) Healthy() bool {
+	return report.Err() == nil
+}
+
+// healthProbeWorkerSampleSize bounds how many worker nodes are probed so
+// health checks stay cheap on large clusters.
+const healthProbeWorkerSampleSize = 3
+
+func (cluster *Cluster) healthProbeNodes() []string {
+	controlPlaneNodes, workerNodes := cluster.nodeSnapshot()
+
+	nodes := make([]string, 0, len(controlPlaneNodes)+healthProbeWorkerSampleSize)
+	nodes = append(nodes, controlPlaneNodes...)
+
+	sample := workerNodes
+	if len(sample) > healthProbeWorkerSampleSize {
+		sample = sample[:healthProbeWorkerSampleSize]
+	}
+
+	return append(nodes, sample...)
+}
+
+// HealthStream fans out a single-pass health probe to every control plane
+// node and a sample of worker nodes, streaming each node's result as it
+// completes.
+func (cluster *Cluster) HealthStream(ctx context.Context) <-chan NodeHealthResult {
+	results := make(chan NodeHealthResult)
+
+	nodes := cluster.healthProbeNodes()
+
+	go func() {
+		defer close(results)
+
+		var wg sync.WaitGroup
+
+		for _, node := range nodes {
+			node := node
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				start := time.Now()
+				err := cluster.probeNode(ctx, node)
+
+				select {
+				case results <- NodeHealthResult{Node: node, Stage: "healthcheck", Err: err, Duration: time.Since(start)}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+		wg.Wait()
+	}()
+
+	return results
+}
+
+func (cluster *Cluster) healthReport(ctx context.Context) ClusterHealthReport {
+	var report ClusterHealthReport
+
+	for result := range cluster.HealthStream(ctx) {
+		report.Results = append(report.Results, result)
+	}
+
+	return report
+}
+
+// Health runs the healthcheck for the cluster, retrying for up to 5 minutes
+// as sometimes bootstrap bootkube issues break the check early on.
 func (cluster *Cluster) Health(ctx context.Context) error {
-	return retry.Constant(5*time.Minute, retry.WithUnits(10*time.Second)).Retry(func() error {
-		// retry health checks as sometimes bootstrap bootkube issues break the check
-		return retry.ExpectedError(cluster.health(ctx))
+	var report ClusterHealthReport
+
+	retryErr := retry.Constant(5*time.Minute, retry.WithUnits(10*time.Second)).Retry(func() error {
+		report = cluster.healthReport(ctx)
+
+		return retry.ExpectedError(report.Err())
 	})
+	if retryErr == nil {
+		return nil
+	}
+
+	if err := report.Err(); err != nil {
+		return err
+	}
+
+	return retryErr
 }
 
-func (cluster *Cluster) health(ctx context.Context) error {
-	resp, err := cluster.client.ClusterHealthCheck(talosclient.WithNodes(ctx, cluster.controlPlaneNodes[0]), 3*time.Minute, &talosclusterapi.ClusterInfo{
-		ControlPlaneNodes: cluster.controlPlaneNodes,
-		WorkerNodes:       cluster.workerNodes,
+func (cluster *Cluster) probeNode(ctx context.Context, node string) error {
+	controlPlaneNodes, workerNodes := cluster.nodeSnapshot()
+
+	resp, err := cluster.client.ClusterHealthCheck(talosclient.WithNodes(ctx, node), 3*time.Minute, &talosclusterapi.ClusterInfo{
+		ControlPlaneNodes: controlPlaneNodes,
+		WorkerNodes:       workerNodes,
 	})
 	if err != nil {
 		return err
@@ -190,8 +916,6 @@ func (cluster *Cluster) health(ctx context.Context) error {
 		if msg.GetMetadata().GetError() != "" {
 			return fmt.Errorf("healthcheck error: %s", msg.GetMetadata().GetError())
 		}
-
-		fmt.Fprintln(os.Stderr, msg.GetMessage())
 	}
 }
 
@@ -207,7 +931,105 @@ func (cluster *Cluster) BridgeIP() netip.Addr {
 
 // SideroComponentsIP returns the IP for the Sidero components (TFTP, iPXE, etc.).
 func (cluster *Cluster) SideroComponentsIP() net.IP {
-	panic("not implemented yet")
+	ip, err := cluster.resolveSideroComponentsIP(context.Background())
+	if err != nil {
+		// best effort: fall back to the bridge IP, which is always reachable
+		// in the local docker-based test environment. Warn so a real,
+		// non-docker deployment doesn't silently mask an actual API-server
+		// failure as the intentional docker-env fallback.
+		log.Printf("WARN: failed to resolve Sidero components IP, falling back to bridge IP %s: %s", cluster.bridgeIP, err)
+
+		return net.IP(cluster.bridgeIP.AsSlice())
+	}
+
+	return ip
+}
+
+// ComponentEndpoints are the resolved host:port pairs for the Sidero services
+// reachable from inside a workload cluster.
+type ComponentEndpoints struct {
+	TFTP       string
+	IPXEHTTP   string
+	Metadata   string
+	SideroLink string
+	EventSink  string
+}
+
+// ComponentsEndpoints resolves host:port pairs for every Sidero service so
+// callers don't have to reimplement the IP lookup done by SideroComponentsIP.
+func (cluster *Cluster) ComponentsEndpoints(ctx context.Context) (ComponentEndpoints, error) {
+	ip, err := cluster.resolveSideroComponentsIP(ctx)
+	if err != nil {
+		return ComponentEndpoints{}, err
+	}
+
+	host := ip.String()
+
+	return ComponentEndpoints{
+		TFTP:       net.JoinHostPort(host, strconv.Itoa(tftpPort)),
+		IPXEHTTP:   net.JoinHostPort(host, strconv.Itoa(ipxeHTTPPort)),
+		Metadata:   net.JoinHostPort(host, strconv.Itoa(metadataPort)),
+		SideroLink: net.JoinHostPort(host, strconv.Itoa(sideroLinkPort)),
+		EventSink:  net.JoinHostPort(host, strconv.Itoa(eventSinkPort)),
+	}, nil
+}
+
+func (cluster *Cluster) resolveSideroComponentsIP(ctx context.Context) (net.IP, error) {
+	cluster.componentsIP.mu.Lock()
+	defer cluster.componentsIP.mu.Unlock()
+
+	if cluster.componentsIP.ip != nil && time.Now().Before(cluster.componentsIP.expiresAt) {
+		return cluster.componentsIP.ip, nil
+	}
+
+	ip, err := cluster.lookupSideroComponentsIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster.componentsIP.ip = ip
+	cluster.componentsIP.expiresAt = time.Now().Add(sideroComponentsIPTTL)
+
+	return ip, nil
+}
+
+// lookupSideroComponentsIP resolves the Sidero components IP: an explicit
+// SIDERO_CONTROLLER_MANAGER_HOST wins, then the sidero-controller-manager
+// Service's cluster IP, falling back to the bridge IP for the local
+// docker-based test environment.
+func (cluster *Cluster) lookupSideroComponentsIP(ctx context.Context) (net.IP, error) {
+	if host := os.Getenv("SIDERO_CONTROLLER_MANAGER_HOST"); host != "" {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return net.IP(addr.AsSlice()), nil
+		}
+
+		if ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host); err == nil && len(ips) > 0 {
+			return ips[0], nil
+		}
+	}
+
+	if cluster.metalClient != nil {
+		var svc v1.Service
+
+		err := cluster.metalClient.Get(ctx, types.NamespacedName{Namespace: "sidero-system", Name: "sidero-controller-manager"}, &svc)
+
+		switch {
+		case err == nil:
+			if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != v1.ClusterIPNone {
+				if ip := net.ParseIP(svc.Spec.ClusterIP); ip != nil {
+					return ip, nil
+				}
+			}
+		case !apierrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+
+	if cluster.bridgeIP.IsValid() {
+		return net.IP(cluster.bridgeIP.AsSlice()), nil
+	}
+
+	return nil, fmt.Errorf("failed to resolve Sidero components IP")
 }
 
 // KubernetesClient provides K8s client source.
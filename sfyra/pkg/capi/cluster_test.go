@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package capi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRunUpgradeRespectsMaxUnavailable(t *testing.T) {
+	nodes := []string{"n1", "n2", "n3", "n4", "n5"}
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+	)
+
+	err := runUpgrade(context.Background(), nodes, 2, func(ctx context.Context, node string) error {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if maxSeen > 2 {
+		t.Fatalf("expected at most 2 nodes upgrading concurrently, saw %d", maxSeen)
+	}
+}
+
+func TestRunUpgradeDefaultsMaxUnavailableToOne(t *testing.T) {
+	nodes := []string{"n1", "n2", "n3"}
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+	)
+
+	err := runUpgrade(context.Background(), nodes, 0, func(ctx context.Context, node string) error {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if maxSeen > 1 {
+		t.Fatalf("expected maxUnavailable to default to 1, saw %d nodes in flight at once", maxSeen)
+	}
+}
+
+func TestRunUpgradePropagatesError(t *testing.T) {
+	boom := errors.New("boom")
+
+	err := runUpgrade(context.Background(), []string{"n1"}, 1, func(ctx context.Context, node string) error {
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected %v, got %v", boom, err)
+	}
+}
+
+func TestUpgradeRejectsUnknownStrategy(t *testing.T) {
+	cluster := &Cluster{controlPlaneNodes: []string{"n1"}}
+
+	err := cluster.Upgrade(context.Background(), UpgradeStrategy("bogus"), "v1.2.3", UpgradeOptions{})
+	if err == nil {
+		t.Fatal("expected an error for an unknown upgrade strategy")
+	}
+}
+
+// TestReplacementTrackerClaimIsExclusive guards against the bug two
+// concurrent Recreate upgrades (MaxUnavailable > 1) used to hit: both
+// waiters observing the same newly-provisioned machine and both declaring
+// it "their" replacement. Only one of any number of concurrent claim calls
+// for the same UID may ever succeed.
+func TestReplacementTrackerClaimIsExclusive(t *testing.T) {
+	var tracker replacementTracker
+
+	const attempts = 50
+
+	uid := types.UID("machine-1")
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed int
+	)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if tracker.claim(uid) {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if claimed != 1 {
+		t.Fatalf("expected exactly 1 of %d concurrent claims for the same UID to succeed, got %d", attempts, claimed)
+	}
+
+	if tracker.claim(uid) {
+		t.Fatal("expected an already-claimed UID to stay claimed")
+	}
+
+	if !tracker.claim(types.UID("machine-2")) {
+		t.Fatal("expected a distinct UID to be claimable")
+	}
+}
+
+func TestUpgradeFlagsStrategy(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    UpgradeStrategy
+		wantErr bool
+	}{
+		{name: "defaults to in-place", args: nil, want: UpgradeStrategyInPlace},
+		{name: "accepts recreate", args: []string{"--upgrade-strategy=Recreate"}, want: UpgradeStrategyRecreate},
+		{name: "rejects unknown strategy", args: []string{"--upgrade-strategy=bogus"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags := RegisterUpgradeFlags(fs)
+
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("failed to parse flags: %s", err)
+			}
+
+			strategy, err := flags.Strategy()
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error for an unknown --upgrade-strategy")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if strategy != tt.want {
+				t.Fatalf("expected strategy %q, got %q", tt.want, strategy)
+			}
+		})
+	}
+}